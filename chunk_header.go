@@ -0,0 +1,243 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// extendedTimestampMarker is the sentinel value a chunk's 3-byte
+// timestamp/delta field is set to when the real value doesn't fit in 24
+// bits; the real value then follows as a 4-byte big-endian field right
+// after the rest of the message header.
+const extendedTimestampMarker = 0xFFFFFF
+
+// chunkBasicHeader is the first 1-3 bytes of a chunk, carrying the chunk
+// type (fmt) and the chunk stream ID.
+type chunkBasicHeader struct {
+	fmt           byte
+	chunkStreamID int
+}
+
+// chunkMessageHeader is the fmt-dependent portion of a chunk header that
+// follows the basic header (timestamp/delta, message length, type id and
+// stream id, depending on fmt). timestamp and timestampDelta always hold
+// the full, already-expanded 32-bit value, regardless of whether the
+// wire form used the 3-byte field or the extended 4-byte field.
+type chunkMessageHeader struct {
+	timestamp       uint32
+	timestampDelta  uint32
+	messageLength   uint32
+	messageTypeID   byte
+	messageStreamID uint32
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func decodeChunkBasicHeader(r byteReader, bh *chunkBasicHeader) error {
+	b0, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	bh.fmt = b0 >> 6
+	csid := int(b0 & 0x3f)
+
+	switch csid {
+	case 0:
+		// 2-byte form: chunk stream ids 64-319.
+		b1, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		csid = int(b1) + 64
+
+	case 1:
+		// 3-byte form: chunk stream ids 64-65599.
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return err
+		}
+		csid = int(buf[0]) + int(buf[1])*256 + 64
+	}
+
+	bh.chunkStreamID = csid
+
+	return nil
+}
+
+func encodeChunkBasicHeader(w io.Writer, bh *chunkBasicHeader) error {
+	switch {
+	case bh.chunkStreamID >= 2 && bh.chunkStreamID <= 63:
+		_, err := w.Write([]byte{bh.fmt<<6 | byte(bh.chunkStreamID)})
+		return err
+
+	case bh.chunkStreamID >= 64 && bh.chunkStreamID <= 319:
+		_, err := w.Write([]byte{bh.fmt << 6, byte(bh.chunkStreamID - 64)})
+		return err
+
+	case bh.chunkStreamID >= 320 && bh.chunkStreamID <= 65599:
+		v := bh.chunkStreamID - 64
+		_, err := w.Write([]byte{bh.fmt<<6 | 0x01, byte(v), byte(v >> 8)})
+		return err
+
+	default:
+		return errors.Errorf("chunk stream id out of range: %d", bh.chunkStreamID)
+	}
+}
+
+// decodeChunkMessageHeader parses the fmt-dependent message header that
+// follows a chunk's basic header. extCont carries the extended-timestamp
+// state recorded for this chunk stream from its most recent fmt 0/1/2
+// header: fmt=3 chunks have no timestamp field of their own, but must
+// still consume a 4-byte extended timestamp if that prior header had
+// one. It returns whether this header (fmt 0/1/2's own field, or the one
+// fmt=3 echoes) was extended.
+func decodeChunkMessageHeader(r byteReader, fmtType byte, mh *chunkMessageHeader, extCont bool) (bool, error) {
+	switch fmtType {
+	case 0:
+		var buf [11]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return false, err
+		}
+		mh.timestamp = uint24(buf[0:3])
+		mh.messageLength = uint24(buf[3:6])
+		mh.messageTypeID = buf[6]
+		mh.messageStreamID = binary.LittleEndian.Uint32(buf[7:11])
+
+		return decodeExtendedTimestamp(r, &mh.timestamp)
+
+	case 1:
+		var buf [7]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return false, err
+		}
+		mh.timestampDelta = uint24(buf[0:3])
+		mh.messageLength = uint24(buf[3:6])
+		mh.messageTypeID = buf[6]
+
+		return decodeExtendedTimestamp(r, &mh.timestampDelta)
+
+	case 2:
+		var buf [3]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return false, err
+		}
+		mh.timestampDelta = uint24(buf[:])
+
+		return decodeExtendedTimestamp(r, &mh.timestampDelta)
+
+	case 3:
+		if !extCont {
+			return false, nil
+		}
+		// mh is freshly zero-valued here, so timestampDelta never holds
+		// the sentinel decodeExtendedTimestamp checks for. Force it so
+		// the 4 extended bytes this continuation carries are consumed.
+		mh.timestampDelta = extendedTimestampMarker
+		return decodeExtendedTimestamp(r, &mh.timestampDelta)
+
+	default:
+		return false, errors.Wrapf(ErrInvalidChunkFmt, "fmt=%d", fmtType)
+	}
+}
+
+func encodeChunkMessageHeader(w io.Writer, fmtType byte, mh *chunkMessageHeader) error {
+	switch fmtType {
+	case 0:
+		var buf [11]byte
+		putUint24(buf[0:3], clampExtended(mh.timestamp))
+		putUint24(buf[3:6], mh.messageLength)
+		buf[6] = mh.messageTypeID
+		binary.LittleEndian.PutUint32(buf[7:11], mh.messageStreamID)
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+		return encodeExtendedTimestamp(w, mh.timestamp)
+
+	case 1:
+		var buf [7]byte
+		putUint24(buf[0:3], clampExtended(mh.timestampDelta))
+		putUint24(buf[3:6], mh.messageLength)
+		buf[6] = mh.messageTypeID
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+		return encodeExtendedTimestamp(w, mh.timestampDelta)
+
+	case 2:
+		var buf [3]byte
+		putUint24(buf[:], clampExtended(mh.timestampDelta))
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+		return encodeExtendedTimestamp(w, mh.timestampDelta)
+
+	case 3:
+		return encodeExtendedTimestamp(w, mh.timestampDelta)
+
+	default:
+		return errors.Wrapf(ErrInvalidChunkFmt, "fmt=%d", fmtType)
+	}
+}
+
+// decodeExtendedTimestamp reads the 4-byte extended timestamp field into
+// *v when *v currently holds the 0xFFFFFF sentinel, reporting whether it
+// did so.
+func decodeExtendedTimestamp(r io.Reader, v *uint32) (bool, error) {
+	if *v != extendedTimestampMarker {
+		return false, nil
+	}
+
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return false, err
+	}
+	*v = binary.BigEndian.Uint32(buf[:])
+
+	return true, nil
+}
+
+// encodeExtendedTimestamp writes v as a 4-byte extended timestamp field
+// if it doesn't fit in the chunk header's 24-bit timestamp/delta field.
+func encodeExtendedTimestamp(w io.Writer, v uint32) error {
+	if v < extendedTimestampMarker {
+		return nil
+	}
+
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// clampExtended returns the value to place in a chunk header's 24-bit
+// timestamp/delta field: v itself if it fits, otherwise the sentinel
+// that signals a following 4-byte extended timestamp.
+func clampExtended(v uint32) uint32 {
+	if v >= extendedTimestampMarker {
+		return extendedTimestampMarker
+	}
+	return v
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
@@ -0,0 +1,45 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzDecodeChunkBasicHeader exercises decodeChunkBasicHeader with
+// arbitrary input; now that chunk parsing no longer panics, it should
+// only ever return a value or an error, never crash the process.
+func FuzzDecodeChunkBasicHeader(f *testing.F) {
+	f.Add([]byte{0x03})
+	f.Add([]byte{0x40, 0x00})
+	f.Add([]byte{0x81, 0xff, 0x00})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, wire []byte) {
+		var bh chunkBasicHeader
+		r := &ChunkStreamerReader{reader: bytes.NewReader(wire)}
+		_ = decodeChunkBasicHeader(r, &bh) // must not panic
+	})
+}
+
+// FuzzDecodeChunkMessageHeader exercises decodeChunkMessageHeader across
+// all four fmt values and both extCont states with arbitrary input.
+func FuzzDecodeChunkMessageHeader(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, byte(0), false)
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0}, byte(1), false)
+	f.Add([]byte{0, 0, 0}, byte(2), false)
+	f.Add([]byte{}, byte(3), true)
+	f.Add([]byte{0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}, byte(0), false)
+
+	f.Fuzz(func(t *testing.T, wire []byte, fmtType byte, extCont bool) {
+		var mh chunkMessageHeader
+		r := &ChunkStreamerReader{reader: bytes.NewReader(wire)}
+		_, _ = decodeChunkMessageHeader(r, fmtType, &mh, extCont) // must not panic, even for fmtType > 3
+	})
+}
@@ -0,0 +1,161 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeChunkBasicHeaderForms(t *testing.T) {
+	cases := []struct {
+		name     string
+		wire     []byte
+		wantFmt  byte
+		wantCSID int
+	}{
+		{"1-byte", []byte{0x03}, 0, 3},
+		{"2-byte", []byte{0x40, 0x00}, 1, 64},
+		{"3-byte", []byte{0x81, 0xff, 0x00}, 2, 319},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var bh chunkBasicHeader
+			r := &ChunkStreamerReader{reader: bytes.NewReader(c.wire)}
+			require.NoError(t, decodeChunkBasicHeader(r, &bh))
+			assert.Equal(t, c.wantFmt, bh.fmt)
+			assert.Equal(t, c.wantCSID, bh.chunkStreamID)
+		})
+	}
+}
+
+func TestEncodeDecodeChunkBasicHeaderRoundTrip(t *testing.T) {
+	for _, csid := range []int{2, 63, 64, 319, 320, 65599} {
+		var buf bytes.Buffer
+		bh := chunkBasicHeader{fmt: 1, chunkStreamID: csid}
+		require.NoError(t, encodeChunkBasicHeader(&buf, &bh))
+
+		var got chunkBasicHeader
+		r := &ChunkStreamerReader{reader: &buf}
+		require.NoError(t, decodeChunkBasicHeader(r, &got))
+		assert.Equal(t, bh, got)
+	}
+}
+
+func TestExtendedTimestampFmt0RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	const bigTimestamp = extendedTimestampMarker + 12345 // > 4.6 hours in ms
+
+	mh := chunkMessageHeader{
+		timestamp:       bigTimestamp,
+		messageLength:   10,
+		messageTypeID:   9,
+		messageStreamID: 1,
+	}
+	require.NoError(t, encodeChunkMessageHeader(&buf, 0, &mh))
+
+	var got chunkMessageHeader
+	r := &ChunkStreamerReader{reader: &buf}
+	hasExtended, err := decodeChunkMessageHeader(r, 0, &got, false)
+	require.NoError(t, err)
+	assert.True(t, hasExtended)
+	assert.Equal(t, uint32(bigTimestamp), got.timestamp)
+}
+
+func TestExtendedTimestampFmt3ContinuationCarriesExtendedField(t *testing.T) {
+	var buf bytes.Buffer
+	const bigDelta = extendedTimestampMarker + 1
+
+	// fmt=1 header establishes the extended delta for this chunk stream.
+	mh := chunkMessageHeader{timestampDelta: bigDelta, messageLength: 5, messageTypeID: 8}
+	require.NoError(t, encodeChunkMessageHeader(&buf, 1, &mh))
+
+	// A fmt=3 continuation re-emits the same extended timestamp field.
+	require.NoError(t, encodeChunkMessageHeader(&buf, 3, &mh))
+
+	r := &ChunkStreamerReader{reader: &buf}
+
+	var got1 chunkMessageHeader
+	hasExtended1, err := decodeChunkMessageHeader(r, 1, &got1, false)
+	require.NoError(t, err)
+	require.True(t, hasExtended1)
+	assert.Equal(t, uint32(bigDelta), got1.timestampDelta)
+
+	var got3 chunkMessageHeader
+	hasExtended3, err := decodeChunkMessageHeader(r, 3, &got3, hasExtended1)
+	require.NoError(t, err)
+	assert.True(t, hasExtended3)
+	assert.Equal(t, uint32(bigDelta), got3.timestampDelta)
+}
+
+func TestFmt3WithoutPriorExtendedStateReadsNoExtraBytes(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(0xAA) // would be misread as part of an extended field if consumed
+
+	r := &ChunkStreamerReader{reader: &buf}
+	var mh chunkMessageHeader
+	hasExtended, err := decodeChunkMessageHeader(r, 3, &mh, false)
+	require.NoError(t, err)
+	assert.False(t, hasExtended)
+	assert.Equal(t, 1, buf.Len()) // untouched
+}
+
+func TestDecodeChunkMessageHeaderRejectsInvalidFmt(t *testing.T) {
+	r := &ChunkStreamerReader{reader: bytes.NewReader(nil)}
+	var mh chunkMessageHeader
+	_, err := decodeChunkMessageHeader(r, 4, &mh, false)
+	require.Error(t, err)
+	assert.Same(t, ErrInvalidChunkFmt, errors.Cause(err))
+}
+
+func TestEncodeChunkMessageHeaderRejectsInvalidFmt(t *testing.T) {
+	var buf bytes.Buffer
+	err := encodeChunkMessageHeader(&buf, 4, &chunkMessageHeader{})
+	require.Error(t, err)
+	assert.Same(t, ErrInvalidChunkFmt, errors.Cause(err))
+}
+
+func TestChunkStreamerWriteChunkEncodesExtendedTimestamp(t *testing.T) {
+	var wire bytes.Buffer
+	cs := NewChunkStreamer(new(bytes.Buffer), &wire, &StreamControlStateConfig{})
+	defer cs.Close()
+
+	const bigTimestamp = extendedTimestampMarker + 999
+
+	w, err := cs.NewChunkWriter(4)
+	require.NoError(t, err)
+	w.buf.Write([]byte("hello"))
+	w.timestamp = bigTimestamp
+	w.messageLength = uint32(w.buf.Len())
+	w.messageTypeID = 18
+	w.messageStreamID = 1
+
+	stream := &chunkStream{
+		basicHeader:   chunkBasicHeader{chunkStreamID: 4},
+		messageHeader: chunkMessageHeader{timestamp: extendedTimestampMarker},
+	}
+	isCompleted, err := cs.writeChunk(stream, w)
+	require.NoError(t, err)
+	assert.True(t, isCompleted)
+
+	r := &ChunkStreamerReader{reader: &wire}
+	var bh chunkBasicHeader
+	require.NoError(t, decodeChunkBasicHeader(r, &bh))
+	assert.Equal(t, byte(0), bh.fmt)
+
+	var mh chunkMessageHeader
+	hasExtended, err := decodeChunkMessageHeader(r, bh.fmt, &mh, false)
+	require.NoError(t, err)
+	assert.True(t, hasExtended)
+	assert.Equal(t, uint32(bigTimestamp), mh.timestamp)
+}
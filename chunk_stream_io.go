@@ -0,0 +1,51 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"io"
+)
+
+// ChunkStreamerReader wraps the connection's raw io.Reader, tracking the
+// total number of bytes read so far so the ack window can be enforced.
+type ChunkStreamerReader struct {
+	reader         io.Reader
+	totalReadBytes uint64
+}
+
+func (r *ChunkStreamerReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.totalReadBytes += uint64(n)
+	return n, err
+}
+
+func (r *ChunkStreamerReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	if _, err := io.ReadFull(r.reader, buf[:]); err != nil {
+		return 0, err
+	}
+	r.totalReadBytes++
+	return buf[0], nil
+}
+
+// ChunkStreamerWriter wraps the connection's raw io.Writer.
+type ChunkStreamerWriter struct {
+	writer io.Writer
+}
+
+func (w *ChunkStreamerWriter) Write(p []byte) (int, error) {
+	return w.writer.Write(p)
+}
+
+// Flush passes through to the underlying writer's Flush, if it buffers.
+func (w *ChunkStreamerWriter) Flush() error {
+	if f, ok := w.writer.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
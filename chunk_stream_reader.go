@@ -0,0 +1,44 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"bytes"
+)
+
+// ChunkStreamReader holds the per-chunk-stream-ID state needed to
+// reassemble an incoming RTMP message out of one or more chunks.
+type ChunkStreamReader struct {
+	basicHeader   chunkBasicHeader
+	messageHeader chunkMessageHeader
+
+	timestamp       uint64
+	timestampDelta  uint32
+	messageLength   uint32
+	messageTypeID   byte
+	messageStreamID uint32
+
+	// extTimestamp records whether the most recently decoded fmt 0/1/2
+	// header for this chunk stream carried an extended timestamp field,
+	// so a following fmt=3 continuation chunk knows to consume one too.
+	extTimestamp bool
+
+	buf *bytes.Buffer
+}
+
+func (r *ChunkStreamReader) Read(p []byte) (int, error) {
+	return r.buf.Read(p)
+}
+
+// Close resets the reassembly buffer once a complete message has been
+// decoded out of it, readying the reader for the next message on this
+// chunk stream ID.
+func (r *ChunkStreamReader) Close() error {
+	r.buf.Reset()
+	return nil
+}
@@ -0,0 +1,108 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"bytes"
+
+	"github.com/yutopp/go-rtmp/message"
+)
+
+// WriterClass is the scheduling class a ChunkStreamWriter belongs to.
+// The writer scheduler services higher classes ahead of lower ones and
+// only round-robins among writers within the same class.
+type WriterClass int
+
+const (
+	// WriterClassControl covers protocol control messages (chunk size,
+	// window ack size, ...) and AMF command messages. Never dropped.
+	WriterClassControl WriterClass = iota
+	WriterClassAudio
+	WriterClassVideo
+	WriterClassData
+)
+
+// ChunkStreamWriter holds one outgoing RTMP message's encoded body and
+// header fields, from the point it is handed to the scheduler until it
+// has been fully chunked out onto the wire. A chunk stream ID may have
+// several ChunkStreamWriters alive at once: one active (possibly
+// already partially written) and the rest queued behind it; the
+// fmt-negotiation state shared across messages on a chunk stream lives
+// on the scheduler's chunkStream, not here.
+type ChunkStreamWriter struct {
+	chunkStreamID int
+
+	timestamp       uint32
+	messageLength   uint32
+	messageTypeID   byte
+	messageStreamID uint32
+
+	// Priority overrides the class the scheduler would otherwise infer
+	// from messageTypeID. Only consulted once set via SetPriority.
+	Priority    WriterClass
+	hasPriority bool
+
+	// KeyFrame marks a queued video message as a keyframe so the
+	// scheduler's video drop policy never sheds it under backpressure.
+	KeyFrame bool
+
+	buf bytes.Buffer
+}
+
+// SetPriority pins this writer's scheduling class, bypassing inference
+// from messageTypeID. Useful for applications that multiplex custom
+// message types over a chunk stream normally used for another class.
+func (w *ChunkStreamWriter) SetPriority(class WriterClass) {
+	w.Priority = class
+	w.hasPriority = true
+}
+
+func (w *ChunkStreamWriter) Read(p []byte) (int, error) {
+	return w.buf.Read(p)
+}
+
+func (w *ChunkStreamWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// class returns the effective scheduling class for this writer: the
+// pinned Priority if one was set via SetPriority, otherwise a class
+// inferred from the RTMP message type id.
+func (w *ChunkStreamWriter) class() WriterClass {
+	if w.hasPriority {
+		return w.Priority
+	}
+	return classifyMessageTypeID(message.TypeID(w.messageTypeID))
+}
+
+// classifyMessageTypeID maps an RTMP message type id to a scheduling
+// class. Protocol control and AMF command messages are WriterClassControl
+// so they are never starved or dropped; everything else that isn't
+// audio/video falls back to WriterClassData.
+func classifyMessageTypeID(typeID message.TypeID) WriterClass {
+	switch typeID {
+	case message.TypeIDSetChunkSize,
+		message.TypeIDAbort,
+		message.TypeIDAck,
+		message.TypeIDUserControl,
+		message.TypeIDWindowAckSize,
+		message.TypeIDSetPeerBandwidth,
+		message.TypeIDCommandMessageAMF0,
+		message.TypeIDCommandMessageAMF3:
+		return WriterClassControl
+
+	case message.TypeIDAudioMessage:
+		return WriterClassAudio
+
+	case message.TypeIDVideoMessage:
+		return WriterClassVideo
+
+	default:
+		return WriterClassData
+	}
+}
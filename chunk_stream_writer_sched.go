@@ -0,0 +1,333 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"math"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// writerClassOrder lists the scheduling classes from highest to lowest
+// priority. Control chunks are always serviced before audio, audio
+// before video, video before data; writers within a class are serviced
+// round-robin.
+var writerClassOrder = []WriterClass{
+	WriterClassControl,
+	WriterClassAudio,
+	WriterClassVideo,
+	WriterClassData,
+}
+
+// chunkStream is the scheduler's bookkeeping for a single chunk stream
+// ID: the fmt-negotiation header state shared by every message sent on
+// it, the writer currently being flushed to the wire, and any writers
+// queued behind it.
+type chunkStream struct {
+	class WriterClass
+
+	// basicHeader/messageHeader hold the last header actually written
+	// to the wire for this chunk stream ID, so writeChunk can keep
+	// diffing against it across messages (fmt 1/2/3 compression).
+	basicHeader   chunkBasicHeader
+	messageHeader chunkMessageHeader
+
+	// active is the writer currently being chunked out. It may be
+	// partially sent already, so it must never be dropped.
+	active *ChunkStreamWriter
+
+	// pending holds writers queued behind active, oldest first.
+	pending []*ChunkStreamWriter
+
+	// queuedBytes is the total length of pending (not yet active)
+	// writers, used to compare against WriterHighWaterMark.
+	queuedBytes int
+}
+
+// chunkStreamerWriterSched is the priority-aware scheduler that decides,
+// across all chunk stream IDs with outstanding writes, which one gets to
+// write its next chunk. Higher-priority classes (control, then audio,
+// then video, then data) are always serviced ahead of lower ones; within
+// a class, chunk stream IDs are serviced round-robin.
+type chunkStreamerWriterSched struct {
+	streamer *ChunkStreamer
+	config   *StreamControlStateConfig
+
+	m sync.Mutex
+
+	streams map[int]*chunkStream
+
+	// order lists the chunk stream IDs with outstanding writes, grouped
+	// by class, in round-robin order.
+	order  map[WriterClass][]int
+	cursor map[WriterClass]int
+
+	activeCh chan bool
+	closeCh  chan struct{}
+	isClosed bool
+}
+
+func newChunkStreamerWriterSched(config *StreamControlStateConfig) *chunkStreamerWriterSched {
+	return &chunkStreamerWriterSched{
+		config:   config,
+		streams:  make(map[int]*chunkStream),
+		order:    make(map[WriterClass][]int),
+		cursor:   make(map[WriterClass]int),
+		activeCh: make(chan bool, 1),
+		closeCh:  make(chan struct{}),
+	}
+}
+
+// Sched enqueues writer for transmission. If its chunk stream ID has no
+// writer in flight, writer becomes active immediately; otherwise it is
+// queued behind the in-flight writer, subject to the class's drop
+// policy (see enqueue).
+func (sched *chunkStreamerWriterSched) Sched(writer *ChunkStreamWriter) error {
+	sched.m.Lock()
+	defer sched.m.Unlock()
+
+	id := writer.chunkStreamID
+	class := writer.class()
+
+	stream, ok := sched.streams[id]
+	if !ok {
+		stream = &chunkStream{
+			class:       class,
+			basicHeader: chunkBasicHeader{chunkStreamID: id},
+			messageHeader: chunkMessageHeader{
+				timestamp: math.MaxUint32, // forces fmt=0 on the first message
+			},
+		}
+		sched.streams[id] = stream
+	}
+	stream.class = class
+
+	if stream.active == nil {
+		stream.active = writer
+		sched.enroll(class, id)
+		sched.activate()
+		return nil
+	}
+
+	sched.enqueue(stream, writer)
+	sched.activate()
+
+	return nil
+}
+
+// enqueue appends writer to stream.pending, applying the class's
+// backpressure policy: VideoDropPolicy once the queue is over
+// WriterHighWaterMark, and the AudioQueueLimit bound on audio regardless
+// of WriterHighWaterMark. The
+// writer currently in flight (stream.active) is never touched: it may
+// already be partially written to the wire.
+func (sched *chunkStreamerWriterSched) enqueue(stream *chunkStream, writer *ChunkStreamWriter) {
+	hwm := 0
+	var videoPolicy VideoDropPolicy
+	audioLimit := 0
+	if sched.config != nil {
+		hwm = sched.config.WriterHighWaterMark
+		videoPolicy = sched.config.VideoDropPolicy
+		audioLimit = sched.config.AudioQueueLimit
+	}
+	backedUp := hwm > 0 && stream.queuedBytes > hwm
+
+	switch stream.class {
+	case WriterClassControl:
+		// Never drop control chunks.
+
+	case WriterClassVideo:
+		if backedUp {
+			// The incoming writer is itself subject to the policy, not
+			// just what's already queued: otherwise the queue keeps
+			// growing by one per call whenever the peer can't drain it.
+			candidates := append(stream.pending, writer)
+			switch videoPolicy {
+			case VideoDropPolicyDropNonKeyframe:
+				stream.pending, stream.queuedBytes = dropNonKeyframes(candidates)
+			case VideoDropPolicyCoalesce:
+				stream.pending, stream.queuedBytes = coalesceNonKeyframes(candidates)
+			default:
+				stream.pending = candidates
+				stream.queuedBytes += int(writer.messageLength)
+			}
+			return
+		}
+
+	case WriterClassAudio:
+		for audioLimit > 0 && len(stream.pending) >= audioLimit {
+			dropped := stream.pending[0]
+			stream.pending = stream.pending[1:]
+			stream.queuedBytes -= int(dropped.messageLength)
+		}
+
+	case WriterClassData:
+		// No backlog policy defined for data yet: queue unconditionally.
+	}
+
+	stream.pending = append(stream.pending, writer)
+	stream.queuedBytes += int(writer.messageLength)
+}
+
+// dropNonKeyframes discards every pending, not-yet-partially-sent video
+// writer that isn't a keyframe, keeping relative order of the survivors.
+func dropNonKeyframes(pending []*ChunkStreamWriter) ([]*ChunkStreamWriter, int) {
+	kept := pending[:0]
+	size := 0
+	for _, w := range pending {
+		if w.KeyFrame {
+			kept = append(kept, w)
+			size += int(w.messageLength)
+		}
+	}
+	return kept, size
+}
+
+// coalesceNonKeyframes discards every pending non-keyframe writer except
+// the newest pending writer, so only the freshest frame of stale video
+// is sent once the stream catches up. Keyframes are always preserved.
+func coalesceNonKeyframes(pending []*ChunkStreamWriter) ([]*ChunkStreamWriter, int) {
+	if len(pending) == 0 {
+		return pending, 0
+	}
+
+	kept := pending[:0]
+	size := 0
+	for i, w := range pending {
+		if w.KeyFrame || i == len(pending)-1 {
+			kept = append(kept, w)
+			size += int(w.messageLength)
+		}
+	}
+	return kept, size
+}
+
+// enroll records chunk stream id as having outstanding work under class,
+// if it isn't already tracked.
+func (sched *chunkStreamerWriterSched) enroll(class WriterClass, id int) {
+	for _, existing := range sched.order[class] {
+		if existing == id {
+			return
+		}
+	}
+	sched.order[class] = append(sched.order[class], id)
+}
+
+// unenroll removes chunk stream id from class's round-robin order once
+// it has no active or pending writers left.
+func (sched *chunkStreamerWriterSched) unenroll(class WriterClass, id int) {
+	ids := sched.order[class]
+	for i, existing := range ids {
+		if existing == id {
+			sched.order[class] = append(ids[:i], ids[i+1:]...)
+			if sched.cursor[class] > i {
+				sched.cursor[class]--
+			}
+			return
+		}
+	}
+}
+
+func (sched *chunkStreamerWriterSched) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errTmp, ok := r.(error)
+			if !ok {
+				errTmp = errors.Errorf("Panic: %+v", r)
+			}
+			err = errors.WithStack(errTmp)
+		}
+	}()
+
+	for {
+		select {
+		case <-sched.activeCh:
+			if err := sched.runActives(); err != nil {
+				return err
+			}
+		case <-sched.closeCh:
+			return nil
+		}
+	}
+}
+
+func (sched *chunkStreamerWriterSched) Close() error {
+	sched.m.Lock()
+	defer sched.m.Unlock()
+
+	if sched.isClosed {
+		return nil
+	}
+	sched.isClosed = true
+
+	close(sched.closeCh)
+
+	return nil
+}
+
+// runActives writes one chunk for every chunk stream ID that has an
+// active writer, visiting classes highest priority first and, within a
+// class, starting at the round-robin cursor. A writer
+// that completes is replaced by its next pending writer (if any); a
+// writer that is still in flight keeps its place.
+func (sched *chunkStreamerWriterSched) runActives() error {
+	sched.m.Lock()
+	defer sched.m.Unlock()
+
+	for _, class := range writerClassOrder {
+		// Iterate over a snapshot: unenroll below shifts sched.order[class]
+		// (and its cursor) in place.
+		ids := append([]int(nil), sched.order[class]...)
+		start := sched.cursor[class]
+		for i := range ids {
+			pos := (start + i) % len(ids)
+			id := ids[pos]
+			stream := sched.streams[id]
+			if stream.active == nil {
+				continue
+			}
+
+			isCompleted, err := sched.streamer.writeChunk(stream, stream.active)
+			if err != nil {
+				return err
+			}
+			if isCompleted {
+				if len(stream.pending) > 0 {
+					next := stream.pending[0]
+					stream.pending = stream.pending[1:]
+					stream.queuedBytes -= int(next.messageLength)
+					stream.active = next
+				} else {
+					// Leave the chunkStream entry in place (not deleted)
+					// so its negotiated basicHeader/messageHeader persist
+					// across idle periods, same as before this writer was
+					// queued.
+					stream.active = nil
+					sched.unenroll(class, id)
+				}
+			}
+		}
+		if ids := sched.order[class]; len(ids) > 0 {
+			sched.cursor[class] = (sched.cursor[class] + 1) % len(ids)
+		}
+	}
+
+	sched.activate()
+
+	return nil
+}
+
+func (sched *chunkStreamerWriterSched) activate() {
+	if len(sched.streams) > 0 {
+		select {
+		case sched.activeCh <- true:
+		default:
+		}
+	}
+}
@@ -0,0 +1,154 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yutopp/go-rtmp/message"
+)
+
+func newTestWriter(chunkStreamID int, typeID message.TypeID, length int) *ChunkStreamWriter {
+	w := &ChunkStreamWriter{
+		chunkStreamID: chunkStreamID,
+		messageTypeID: byte(typeID),
+	}
+	w.buf.Write(make([]byte, length))
+	w.messageLength = uint32(length)
+	return w
+}
+
+func TestWriterClassificationOrdersControlFirst(t *testing.T) {
+	assert.Equal(t, WriterClassControl, classifyMessageTypeID(message.TypeIDCommandMessageAMF0))
+	assert.Equal(t, WriterClassControl, classifyMessageTypeID(message.TypeIDWindowAckSize))
+	assert.Equal(t, WriterClassAudio, classifyMessageTypeID(message.TypeIDAudioMessage))
+	assert.Equal(t, WriterClassVideo, classifyMessageTypeID(message.TypeIDVideoMessage))
+	assert.Equal(t, WriterClassData, classifyMessageTypeID(message.TypeIDDataMessageAMF0))
+}
+
+func TestSetPriorityOverridesInference(t *testing.T) {
+	w := newTestWriter(4, message.TypeIDDataMessageAMF0, 4)
+	assert.Equal(t, WriterClassData, w.class())
+
+	w.SetPriority(WriterClassVideo)
+	assert.Equal(t, WriterClassVideo, w.class())
+}
+
+func TestSchedServicesControlBeforeAudioBeforeVideoBeforeData(t *testing.T) {
+	sched := newChunkStreamerWriterSched(&StreamControlStateConfig{})
+
+	var order []int
+	for i, typeID := range []message.TypeID{
+		message.TypeIDDataMessageAMF0,
+		message.TypeIDVideoMessage,
+		message.TypeIDCommandMessageAMF0,
+		message.TypeIDAudioMessage,
+	} {
+		require.NoError(t, sched.Sched(newTestWriter(i+3, typeID, 1)))
+	}
+
+	sched.m.Lock()
+	for _, class := range writerClassOrder {
+		order = append(order, sched.order[class]...)
+	}
+	sched.m.Unlock()
+
+	assert.Equal(t, []int{5, 6, 4, 3}, order) // control(5), audio(6), video(4), data(3)
+}
+
+func TestVideoDropPolicyDropsQueuedNonKeyframesWhenBackedUp(t *testing.T) {
+	sched := newChunkStreamerWriterSched(&StreamControlStateConfig{
+		WriterHighWaterMark: 1,
+		VideoDropPolicy:     VideoDropPolicyDropNonKeyframe,
+	})
+
+	// First writer becomes active immediately.
+	require.NoError(t, sched.Sched(newTestWriter(4, message.TypeIDVideoMessage, 8)))
+
+	keyframe := newTestWriter(4, message.TypeIDVideoMessage, 8)
+	keyframe.KeyFrame = true
+	nonKeyframe := newTestWriter(4, message.TypeIDVideoMessage, 8)
+
+	require.NoError(t, sched.Sched(keyframe))
+	require.NoError(t, sched.Sched(nonKeyframe))
+
+	sched.m.Lock()
+	defer sched.m.Unlock()
+	pending := sched.streams[4].pending
+	require.Len(t, pending, 1)
+	assert.True(t, pending[0].KeyFrame)
+}
+
+func TestAudioQueueDropsOldestFrameWhenOverLimit(t *testing.T) {
+	sched := newChunkStreamerWriterSched(&StreamControlStateConfig{
+		AudioQueueLimit: 1,
+	})
+
+	require.NoError(t, sched.Sched(newTestWriter(6, message.TypeIDAudioMessage, 4))) // active
+	oldest := newTestWriter(6, message.TypeIDAudioMessage, 4)
+	newest := newTestWriter(6, message.TypeIDAudioMessage, 4)
+	require.NoError(t, sched.Sched(oldest))
+	require.NoError(t, sched.Sched(newest))
+
+	sched.m.Lock()
+	defer sched.m.Unlock()
+	pending := sched.streams[6].pending
+	require.Len(t, pending, 1)
+	assert.Same(t, newest, pending[0])
+}
+
+func TestControlClassNeverDrops(t *testing.T) {
+	sched := newChunkStreamerWriterSched(&StreamControlStateConfig{
+		WriterHighWaterMark: 1,
+		AudioQueueLimit:     1,
+	})
+
+	require.NoError(t, sched.Sched(newTestWriter(2, message.TypeIDWindowAckSize, 4))) // active
+	for i := 0; i < 5; i++ {
+		require.NoError(t, sched.Sched(newTestWriter(2, message.TypeIDWindowAckSize, 4)))
+	}
+
+	sched.m.Lock()
+	defer sched.m.Unlock()
+	assert.Len(t, sched.streams[2].pending, 5)
+}
+
+func TestDropPolicyNeverTouchesActiveWriter(t *testing.T) {
+	sched := newChunkStreamerWriterSched(&StreamControlStateConfig{
+		WriterHighWaterMark: 1,
+		VideoDropPolicy:     VideoDropPolicyDropNonKeyframe,
+	})
+
+	active := newTestWriter(4, message.TypeIDVideoMessage, 8) // not a keyframe
+	require.NoError(t, sched.Sched(active))
+	require.NoError(t, sched.Sched(newTestWriter(4, message.TypeIDVideoMessage, 8)))
+
+	sched.m.Lock()
+	defer sched.m.Unlock()
+	assert.Same(t, active, sched.streams[4].active)
+}
+
+func TestRoundRobinStartsAtCursorWithinClass(t *testing.T) {
+	sched := newChunkStreamerWriterSched(&StreamControlStateConfig{})
+	sched.enroll(WriterClassVideo, 1)
+	sched.enroll(WriterClassVideo, 2)
+	sched.enroll(WriterClassVideo, 3)
+
+	sched.cursor[WriterClassVideo] = 1
+	ids := append([]int(nil), sched.order[WriterClassVideo]...)
+	require.Len(t, ids, 3)
+
+	// Simulate runActives' starting position for this class: it should
+	// begin at the cursor, not always at index 0, so no chunk stream ID
+	// is starved by always running last.
+	start := sched.cursor[WriterClassVideo]
+	assert.Equal(t, 2, ids[start])
+}
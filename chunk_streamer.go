@@ -10,10 +10,7 @@ package rtmp
 import (
 	"bytes"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"io"
-	"math"
-	"sync"
 
 	"github.com/yutopp/go-rtmp/message"
 )
@@ -23,7 +20,11 @@ type ChunkStreamer struct {
 	w *ChunkStreamerWriter
 
 	readers map[int]*ChunkStreamReader
-	writers map[int]*ChunkStreamWriter
+
+	// pendingFragments holds sub-messages of an AggregateMessage that
+	// have already been split out and are waiting to be handed out one
+	// at a time by Read.
+	pendingFragments []queuedFragment
 
 	writerSched *chunkStreamerWriterSched
 
@@ -35,7 +36,7 @@ type ChunkStreamer struct {
 
 	controlStreamWriter func(chunkStreamID int, timestamp uint32, msg message.Message) error
 
-	logger logrus.FieldLogger
+	logger Logger
 }
 
 func NewChunkStreamer(r io.Reader, w io.Writer, config *StreamControlStateConfig) *ChunkStreamer {
@@ -48,20 +49,15 @@ func NewChunkStreamer(r io.Reader, w io.Writer, config *StreamControlStateConfig
 		},
 
 		readers: make(map[int]*ChunkStreamReader),
-		writers: make(map[int]*ChunkStreamWriter),
 
-		writerSched: &chunkStreamerWriterSched{
-			writers:  make(map[int]*ChunkStreamWriter),
-			activeCh: make(chan bool, 1),
-			closeCh:  make(chan struct{}),
-		},
+		writerSched: newChunkStreamerWriterSched(config),
 
 		selfState: NewStreamControlState(config),
 		peerState: NewStreamControlState(config),
 
 		done: make(chan interface{}),
 
-		logger: logrus.StandardLogger(),
+		logger: loggerFromConfig(config),
 	}
 	cs.writerSched.streamer = cs
 	go cs.schedWriteLoop()
@@ -69,7 +65,31 @@ func NewChunkStreamer(r io.Reader, w io.Writer, config *StreamControlStateConfig
 	return cs
 }
 
+// loggerFromConfig returns config.Logger, falling back to a no-op Logger
+// when it wasn't set.
+func loggerFromConfig(config *StreamControlStateConfig) Logger {
+	if config.Logger != nil {
+		return config.Logger
+	}
+	return nopLogger{}
+}
+
+// queuedFragment is one sub-message of an already-decoded
+// AggregateMessage, waiting to be handed out by a future Read call.
+type queuedFragment struct {
+	chunkStreamID int
+	timestamp     uint32
+	sf            StreamFragment
+}
+
 func (cs *ChunkStreamer) Read(sf *StreamFragment) (int, uint32, error) {
+	if len(cs.pendingFragments) > 0 {
+		fr := cs.pendingFragments[0]
+		cs.pendingFragments = cs.pendingFragments[1:]
+		*sf = fr.sf
+		return fr.chunkStreamID, fr.timestamp, nil
+	}
+
 	reader, err := cs.NewChunkReader()
 	if err != nil {
 		return 0, 0, err
@@ -83,7 +103,48 @@ func (cs *ChunkStreamer) Read(sf *StreamFragment) (int, uint32, error) {
 
 	sf.StreamID = reader.messageStreamID
 
-	return reader.basicHeader.chunkStreamID, uint32(reader.timestamp), nil
+	chunkStreamID := reader.basicHeader.chunkStreamID
+	timestamp := uint32(reader.timestamp)
+
+	agg, ok := sf.Message.(*message.AggregateMessage)
+	if !ok {
+		return chunkStreamID, timestamp, nil
+	}
+
+	if err := cs.fanOutAggregate(chunkStreamID, timestamp, agg); err != nil {
+		return 0, 0, err
+	}
+
+	return cs.Read(sf) // hand out the first fanned-out sub-message instead
+}
+
+// fanOutAggregate splits an Aggregate message (TypeID 22) into its
+// sub-messages and queues each as its own StreamFragment, so callers of
+// Read never need to know an aggregate was involved.
+func (cs *ChunkStreamer) fanOutAggregate(chunkStreamID int, timestamp uint32, agg *message.AggregateMessage) error {
+	items, err := message.DecodeAggregate(timestamp, agg.Payload)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		var msg message.Message
+		dec := message.NewDecoder(bytes.NewReader(item.Payload), item.TypeID)
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+
+		cs.pendingFragments = append(cs.pendingFragments, queuedFragment{
+			chunkStreamID: chunkStreamID,
+			timestamp:     item.Timestamp,
+			sf: StreamFragment{
+				Message:  msg,
+				StreamID: item.StreamID,
+			},
+		})
+	}
+
+	return nil
 }
 
 func (cs *ChunkStreamer) Write(chunkStreamID int, timestamp uint32, sf *StreamFragment) error {
@@ -105,6 +166,45 @@ func (cs *ChunkStreamer) Write(chunkStreamID int, timestamp uint32, sf *StreamFr
 	return cs.Sched(writer)
 }
 
+// WriteAggregate opts in to packing several fragments destined for the
+// same chunk stream ID into a single Aggregate message (TypeID 22),
+// cutting the per-chunk header overhead that high-frame-rate streams
+// would otherwise pay per individual A/V frame. timestamps[i] is the
+// timestamp fragments[i] would have been written with via Write; the
+// aggregate itself is written with timestamps[0] and every fragment's
+// timestamp is re-encoded as a delta from it.
+func (cs *ChunkStreamer) WriteAggregate(chunkStreamID int, fragments []*StreamFragment, timestamps []uint32) error {
+	if len(fragments) == 0 {
+		return nil
+	}
+	if len(fragments) != len(timestamps) {
+		return errors.New("rtmp: fragments and timestamps must be the same length")
+	}
+
+	items := make([]message.AggregateItem, 0, len(fragments))
+	for i, frag := range fragments {
+		var body bytes.Buffer
+		if err := message.NewEncoder(&body).Encode(frag.Message); err != nil {
+			return err
+		}
+
+		items = append(items, message.AggregateItem{
+			TypeID:    frag.Message.TypeID(),
+			Timestamp: timestamps[i],
+			StreamID:  frag.StreamID,
+			Payload:   body.Bytes(),
+		})
+	}
+
+	baseTimestamp := timestamps[0]
+	return cs.Write(chunkStreamID, baseTimestamp, &StreamFragment{
+		Message: &message.AggregateMessage{
+			Payload: message.EncodeAggregate(items, baseTimestamp),
+		},
+		StreamID: fragments[0].StreamID,
+	})
+}
+
 func (cs *ChunkStreamer) NewChunkReader() (*ChunkStreamReader, error) {
 again:
 	isCompleted, reader, err := cs.readChunk()
@@ -124,11 +224,9 @@ again:
 }
 
 func (cs *ChunkStreamer) NewChunkWriter(chunkStreamID int) (*ChunkStreamWriter, error) {
-	writer := cs.prepareChunkWriter(chunkStreamID)
-	writer.m.Lock()
-	defer writer.m.Unlock()
-
-	return writer, nil
+	return &ChunkStreamWriter{
+		chunkStreamID: chunkStreamID,
+	}, nil
 }
 
 func (cs *ChunkStreamer) Sched(writer *ChunkStreamWriter) error {
@@ -163,15 +261,17 @@ func (cs *ChunkStreamer) readChunk() (bool, *ChunkStreamReader, error) {
 	}
 	cs.logger.Debugf("(READ) BasicHeader = %+v", bh)
 
+	reader := cs.prepareChunkReader(bh.chunkStreamID)
+
 	var mh chunkMessageHeader
-	if err := decodeChunkMessageHeader(cs.r, bh.fmt, &mh); err != nil {
+	hasExtended, err := decodeChunkMessageHeader(cs.r, bh.fmt, &mh, reader.extTimestamp)
+	if err != nil {
 		return false, nil, err
 	}
 	cs.logger.Debugf("(READ) MessageHeader = %+v", mh)
 
-	reader := cs.prepareChunkReader(bh.chunkStreamID)
 	reader.basicHeader = bh
-	reader.messageHeader = mh
+	reader.extTimestamp = hasExtended
 
 	switch bh.fmt {
 	case 0:
@@ -193,14 +293,23 @@ func (cs *ChunkStreamer) readChunk() (bool, *ChunkStreamReader, error) {
 		// DO NOTHING
 
 	default:
-		panic("unsupported chunk") // TODO: fix
+		return false, nil, errors.Wrapf(ErrInvalidChunkFmt, "fmt=%d", bh.fmt)
+	}
+	reader.messageHeader = mh
+
+	if maxLen := cs.peerState.config.MaxMessageLength; maxLen != 0 && reader.messageLength > maxLen {
+		return false, nil, errors.Wrapf(
+			ErrChunkSizeExceeded, "length=%d max=%d", reader.messageLength, maxLen,
+		)
 	}
 
 	cs.logger.Debugf("(READ) MessageLength = %d, Current = %d", reader.messageLength, reader.buf.Len())
 
 	expectLen := int(reader.messageLength) - reader.buf.Len()
 	if expectLen <= 0 {
-		panic("invalid state") // TODO fix
+		return false, nil, errors.Wrapf(
+			ErrInvalidChunkState, "messageLength=%d bufferedLength=%d", reader.messageLength, reader.buf.Len(),
+		)
 	}
 
 	if uint32(expectLen) > cs.peerState.chunkSize {
@@ -208,7 +317,7 @@ func (cs *ChunkStreamer) readChunk() (bool, *ChunkStreamReader, error) {
 	}
 	cs.logger.Debugf("(READ) Length = %d", expectLen)
 
-	_, err := io.CopyN(reader.buf, cs.r, int64(expectLen))
+	_, err = io.CopyN(reader.buf, cs.r, int64(expectLen))
 	if err != nil {
 		return false, nil, err
 	}
@@ -225,10 +334,10 @@ func (cs *ChunkStreamer) readChunk() (bool, *ChunkStreamReader, error) {
 	return true, reader, nil
 }
 
-func (cs *ChunkStreamer) writeChunk(writer *ChunkStreamWriter) (bool, error) {
-	cs.updateWriterHeader(writer)
+func (cs *ChunkStreamer) writeChunk(stream *chunkStream, writer *ChunkStreamWriter) (bool, error) {
+	cs.updateWriterHeader(stream, writer)
 
-	cs.logger.Debugf("(WRITE) Headers: Basic = %+v / Message = %+v", writer.basicHeader, writer.messageHeader)
+	cs.logger.Debugf("(WRITE) Headers: Basic = %+v / Message = %+v", stream.basicHeader, stream.messageHeader)
 	//cs.logger.Debugf("(WRITE) Buffer: %+v", writer.buf.Bytes())
 
 	expectLen := writer.buf.Len()
@@ -236,10 +345,10 @@ func (cs *ChunkStreamer) writeChunk(writer *ChunkStreamWriter) (bool, error) {
 		expectLen = int(cs.selfState.chunkSize)
 	}
 
-	if err := encodeChunkBasicHeader(cs.w, &writer.basicHeader); err != nil {
+	if err := encodeChunkBasicHeader(cs.w, &stream.basicHeader); err != nil {
 		return false, err
 	}
-	if err := encodeChunkMessageHeader(cs.w, writer.basicHeader.fmt, &writer.messageHeader); err != nil {
+	if err := encodeChunkMessageHeader(cs.w, stream.basicHeader.fmt, &stream.messageHeader); err != nil {
 		return false, err
 	}
 
@@ -258,34 +367,40 @@ func (cs *ChunkStreamer) writeChunk(writer *ChunkStreamWriter) (bool, error) {
 	return true, nil
 }
 
-func (cs *ChunkStreamer) updateWriterHeader(writer *ChunkStreamWriter) {
+// updateWriterHeader diffs writer's target header fields against
+// stream's last-written header and picks the cheapest fmt (0-3) that
+// can express the difference, updating stream's persisted header to
+// match. stream's header survives across messages on the same chunk
+// stream ID, which is what makes fmt 1/2/3 compression possible.
+func (cs *ChunkStreamer) updateWriterHeader(stream *chunkStream, writer *ChunkStreamWriter) {
 	fmt := byte(2) // default: only timestamp delta
-	if writer.messageHeader.messageLength != writer.messageLength || writer.messageTypeID != writer.messageHeader.messageTypeID {
+	var timestampDelta uint32
+	if stream.messageHeader.messageLength != writer.messageLength || writer.messageTypeID != stream.messageHeader.messageTypeID {
 		// header or type id is updated, change fmt to 1 to notify difference and update state
-		writer.messageHeader.messageLength = writer.messageLength
-		writer.messageHeader.messageTypeID = writer.messageTypeID
+		stream.messageHeader.messageLength = writer.messageLength
+		stream.messageHeader.messageTypeID = writer.messageTypeID
 		fmt = 1
 	}
-	if writer.timestamp != writer.messageHeader.timestamp {
-		if writer.timestamp >= writer.messageHeader.timestamp {
-			writer.timestampDelta = writer.timestamp - writer.messageHeader.timestamp
+	if writer.timestamp != stream.messageHeader.timestamp {
+		if writer.timestamp >= stream.messageHeader.timestamp {
+			timestampDelta = writer.timestamp - stream.messageHeader.timestamp
 		} else {
 			// timestamp is reversed, clear timestamp data
 			fmt = 0
-			writer.timestampDelta = 0
+			timestampDelta = 0
 		}
 	}
-	if writer.timestampDelta == writer.messageHeader.timestampDelta && fmt == 2 {
+	if timestampDelta == stream.messageHeader.timestampDelta && fmt == 2 {
 		fmt = 3
 	}
-	writer.messageHeader.timestampDelta = writer.timestampDelta
-	writer.messageHeader.timestamp = writer.timestamp
+	stream.messageHeader.timestampDelta = timestampDelta
+	stream.messageHeader.timestamp = writer.timestamp
 
-	if writer.messageHeader.messageStreamID != writer.messageStreamID {
+	if stream.messageHeader.messageStreamID != writer.messageStreamID {
 		fmt = 0
-		writer.messageHeader.messageStreamID = writer.messageStreamID
+		stream.messageHeader.messageStreamID = writer.messageStreamID
 	}
-	writer.basicHeader.fmt = fmt
+	stream.basicHeader.fmt = fmt
 }
 
 func (cs *ChunkStreamer) schedWriteLoop() {
@@ -305,23 +420,6 @@ func (cs *ChunkStreamer) prepareChunkReader(chunkStreamID int) *ChunkStreamReade
 	return reader
 }
 
-func (cs *ChunkStreamer) prepareChunkWriter(chunkStreamID int) *ChunkStreamWriter {
-	writer, ok := cs.writers[chunkStreamID]
-	if !ok {
-		writer = &ChunkStreamWriter{
-			basicHeader: chunkBasicHeader{
-				chunkStreamID: chunkStreamID,
-			},
-			messageHeader: chunkMessageHeader{
-				timestamp: math.MaxUint32, // initial state will be updated by writer.timestamp
-			},
-		}
-		cs.writers[chunkStreamID] = writer
-	}
-
-	return writer
-}
-
 func (cs *ChunkStreamer) sendAck() error {
 	cs.logger.Infof("Sending Ack...")
 	// TODO: chunk stream id and fix timestamp
@@ -329,106 +427,3 @@ func (cs *ChunkStreamer) sendAck() error {
 		SequenceNumber: uint32(cs.r.totalReadBytes),
 	})
 }
-
-type chunkStreamerWriterSched struct {
-	streamer *ChunkStreamer
-	writers  map[int]*ChunkStreamWriter
-	m        sync.Mutex
-
-	activeCh chan bool
-	closeCh  chan struct{}
-	isClosed bool
-}
-
-func (sched *chunkStreamerWriterSched) Sched(writer *ChunkStreamWriter) error {
-	sched.m.Lock()
-	defer sched.m.Unlock()
-
-	_, ok := sched.writers[writer.basicHeader.chunkStreamID]
-	if ok {
-		return errors.New("Running writer")
-	}
-
-	writer.m.Lock()
-	sched.writers[writer.basicHeader.chunkStreamID] = writer
-
-	sched.activate()
-
-	return nil
-}
-
-func (sched *chunkStreamerWriterSched) UnSched(writer *ChunkStreamWriter) error {
-	// Lock must be taken before calling this function.
-
-	_, ok := sched.writers[writer.basicHeader.chunkStreamID]
-	if !ok {
-		return errors.New("Not running writer")
-	}
-
-	writer.m.Unlock()
-	delete(sched.writers, writer.basicHeader.chunkStreamID)
-
-	return nil
-}
-
-func (sched *chunkStreamerWriterSched) Run() (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			errTmp, ok := r.(error)
-			if !ok {
-				errTmp = errors.Errorf("Panic: %+v", r)
-			}
-			err = errors.WithStack(errTmp)
-		}
-	}()
-
-	for {
-		select {
-		case <-sched.activeCh:
-			if err := sched.runActives(); err != nil {
-				return err
-			}
-		case <-sched.closeCh:
-			return nil
-		}
-	}
-}
-
-func (sched *chunkStreamerWriterSched) Close() error {
-	sched.m.Lock()
-	defer sched.m.Unlock()
-
-	if sched.isClosed {
-		return nil
-	}
-	sched.isClosed = true
-
-	close(sched.closeCh)
-
-	return nil
-}
-
-func (sched *chunkStreamerWriterSched) runActives() error {
-	sched.m.Lock()
-	defer sched.m.Unlock()
-
-	for _, writer := range sched.writers {
-		isCompleted, err := sched.streamer.writeChunk(writer)
-		if isCompleted || err != nil {
-			_ = sched.UnSched(writer) // TODO: error check
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	sched.activate()
-
-	return nil
-}
-
-func (sched *chunkStreamerWriterSched) activate() {
-	if len(sched.writers) > 0 {
-		sched.activeCh <- true
-	}
-}
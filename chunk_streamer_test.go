@@ -0,0 +1,59 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeFmt0Chunk writes a single fmt=0 chunk (basic header + message
+// header + body) for chunk stream id 3, with no continuation.
+func encodeFmt0Chunk(t *testing.T, messageLength uint32, body []byte) []byte {
+	t.Helper()
+
+	var wire bytes.Buffer
+	require.NoError(t, encodeChunkBasicHeader(&wire, &chunkBasicHeader{fmt: 0, chunkStreamID: 3}))
+	require.NoError(t, encodeChunkMessageHeader(&wire, 0, &chunkMessageHeader{
+		timestamp:       1,
+		messageLength:   messageLength,
+		messageTypeID:   8,
+		messageStreamID: 1,
+	}))
+	wire.Write(body)
+
+	return wire.Bytes()
+}
+
+func TestReadChunkRejectsZeroLengthMessageAsInvalidState(t *testing.T) {
+	wire := encodeFmt0Chunk(t, 0, nil)
+
+	cs := NewChunkStreamer(bytes.NewReader(wire), new(bytes.Buffer), &StreamControlStateConfig{})
+	defer cs.Close()
+
+	_, err := cs.NewChunkReader()
+	require.Error(t, err)
+	assert.Same(t, ErrInvalidChunkState, errors.Cause(err))
+}
+
+func TestReadChunkRejectsMessageLargerThanMaxMessageLength(t *testing.T) {
+	wire := encodeFmt0Chunk(t, 1024, make([]byte, 1024))
+
+	cs := NewChunkStreamer(bytes.NewReader(wire), new(bytes.Buffer), &StreamControlStateConfig{
+		MaxMessageLength: 16,
+	})
+	defer cs.Close()
+
+	_, err := cs.NewChunkReader()
+	require.Error(t, err)
+	assert.Same(t, ErrChunkSizeExceeded, errors.Cause(err))
+}
@@ -0,0 +1,29 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidChunkFmt is returned when a chunk's basic header carries
+	// an fmt value outside the 0-3 range the chunk message header codec
+	// knows how to handle.
+	ErrInvalidChunkFmt = errors.New("rtmp: invalid chunk fmt")
+
+	// ErrInvalidChunkState is returned when a chunk stream's bookkeeping
+	// is inconsistent with the header a peer just sent, e.g. a new
+	// message header whose length is no larger than what has already
+	// been buffered for the chunk stream.
+	ErrInvalidChunkState = errors.New("rtmp: invalid chunk stream state")
+
+	// ErrChunkSizeExceeded is returned when a message's reconstructed
+	// length exceeds StreamControlStateConfig.MaxMessageLength.
+	ErrChunkSizeExceeded = errors.New("rtmp: message length exceeds configured maximum")
+)
@@ -0,0 +1,37 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+// Logger is the logging interface ChunkStreamer writes its diagnostic
+// output through. It is intentionally narrow so that callers can adapt
+// whatever logging library they already use (logrus, zap, the standard
+// library, ...) instead of this package pulling one in for them.
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+
+	// With returns a Logger that annotates every subsequent message with
+	// fields, in addition to whatever fields the receiver already carries.
+	With(fields map[string]interface{}) Logger
+}
+
+// nopLogger is the Logger used when StreamControlStateConfig doesn't
+// provide one: it discards everything.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Warnf(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+func (nopLogger) With(fields map[string]interface{}) Logger {
+	return nopLogger{}
+}
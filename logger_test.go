@@ -0,0 +1,40 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerFromConfigDefaultsToNop(t *testing.T) {
+	logger := loggerFromConfig(&StreamControlStateConfig{})
+	assert.IsType(t, nopLogger{}, logger)
+
+	// Should never panic even though nothing is wired up underneath.
+	logger.Debugf("x")
+	logger.Infof("x")
+	logger.Warnf("x")
+	logger.Errorf("x")
+	assert.IsType(t, nopLogger{}, logger.With(map[string]interface{}{"k": "v"}))
+}
+
+func TestLoggerFromConfigUsesProvidedLogger(t *testing.T) {
+	l := NewLogrusLogger(logrus.StandardLogger())
+	logger := loggerFromConfig(&StreamControlStateConfig{Logger: l})
+	assert.Same(t, l, logger)
+}
+
+func TestLogrusLoggerWithReturnsAnnotatedLogger(t *testing.T) {
+	l := NewLogrusLogger(logrus.StandardLogger())
+	annotated := l.With(map[string]interface{}{"stream": "42"})
+	require.NotNil(t, annotated)
+}
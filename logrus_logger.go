@@ -0,0 +1,44 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// logrusLogger adapts a logrus.FieldLogger to the Logger interface, for
+// callers who want to keep using logrus as this package did before Logger
+// existed.
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+// NewLogrusLogger wraps l as a Logger.
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return &logrusLogger{l: l}
+}
+
+func (a *logrusLogger) Debugf(format string, args ...interface{}) {
+	a.l.Debugf(format, args...)
+}
+
+func (a *logrusLogger) Infof(format string, args ...interface{}) {
+	a.l.Infof(format, args...)
+}
+
+func (a *logrusLogger) Warnf(format string, args ...interface{}) {
+	a.l.Warnf(format, args...)
+}
+
+func (a *logrusLogger) Errorf(format string, args ...interface{}) {
+	a.l.Errorf(format, args...)
+}
+
+func (a *logrusLogger) With(fields map[string]interface{}) Logger {
+	return &logrusLogger{l: a.l.WithFields(logrus.Fields(fields))}
+}
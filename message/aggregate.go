@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package message
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// aggregateSubHeaderLength is the size of one sub-message's header
+// inside an AggregateMessage payload: 1 byte type id, 3 byte body size,
+// 3 byte timestamp, 1 byte timestamp extension, 3 byte stream id.
+const aggregateSubHeaderLength = 11
+
+// aggregateBackPointerLength is the trailing "previous tag size" field
+// FMS writes after every sub-message, mirroring the FLV tag format.
+const aggregateBackPointerLength = 4
+
+// AggregateMessage is TypeID 22: a back-to-back sequence of complete
+// sub-messages (each with its own type, size, timestamp and stream id)
+// packed into a single RTMP message. FMS and some CDNs send these to cut
+// per-chunk header overhead on high-frame-rate streams.
+type AggregateMessage struct {
+	Payload []byte
+}
+
+func (m *AggregateMessage) TypeID() TypeID {
+	return TypeIDAggregateMessage
+}
+
+// AggregateItem is one sub-message out of (or destined for) an
+// AggregateMessage. Timestamp is always the fully reconstructed/absolute
+// value, not the wire-level delta FLV tags use internally.
+type AggregateItem struct {
+	TypeID    TypeID
+	Timestamp uint32
+	StreamID  uint32
+	Payload   []byte
+}
+
+// DecodeAggregate splits an AggregateMessage's payload into its
+// individual sub-messages. baseTimestamp is the timestamp the aggregate
+// message itself was received with; each sub-message's own timestamp
+// field is a delta relative to it.
+func DecodeAggregate(baseTimestamp uint32, payload []byte) ([]AggregateItem, error) {
+	var items []AggregateItem
+
+	off := 0
+	for off < len(payload) {
+		if len(payload)-off < aggregateSubHeaderLength {
+			return nil, errors.New("message: truncated aggregate sub-message header")
+		}
+
+		typeID := TypeID(payload[off])
+		size := int(payload[off+1])<<16 | int(payload[off+2])<<8 | int(payload[off+3])
+		delta := uint32(payload[off+4])<<16 | uint32(payload[off+5])<<8 | uint32(payload[off+6]) | uint32(payload[off+7])<<24
+		streamID := uint32(payload[off+8])<<16 | uint32(payload[off+9])<<8 | uint32(payload[off+10])
+		off += aggregateSubHeaderLength
+
+		if size < 0 || len(payload)-off < size+aggregateBackPointerLength {
+			return nil, errors.New("message: truncated aggregate sub-message body")
+		}
+		body := payload[off : off+size]
+		off += size + aggregateBackPointerLength
+
+		items = append(items, AggregateItem{
+			TypeID:    typeID,
+			Timestamp: baseTimestamp + delta,
+			StreamID:  streamID,
+			Payload:   body,
+		})
+	}
+
+	return items, nil
+}
+
+// EncodeAggregate packs items into an AggregateMessage payload, encoding
+// each item's timestamp as a delta from baseTimestamp.
+func EncodeAggregate(items []AggregateItem, baseTimestamp uint32) []byte {
+	size := 0
+	for _, item := range items {
+		size += aggregateSubHeaderLength + len(item.Payload) + aggregateBackPointerLength
+	}
+
+	out := make([]byte, 0, size)
+	for _, item := range items {
+		delta := item.Timestamp - baseTimestamp
+
+		var hdr [aggregateSubHeaderLength]byte
+		hdr[0] = byte(item.TypeID)
+		hdr[1] = byte(len(item.Payload) >> 16)
+		hdr[2] = byte(len(item.Payload) >> 8)
+		hdr[3] = byte(len(item.Payload))
+		hdr[4] = byte(delta >> 16)
+		hdr[5] = byte(delta >> 8)
+		hdr[6] = byte(delta)
+		hdr[7] = byte(delta >> 24)
+		hdr[8] = byte(item.StreamID >> 16)
+		hdr[9] = byte(item.StreamID >> 8)
+		hdr[10] = byte(item.StreamID)
+
+		out = append(out, hdr[:]...)
+		out = append(out, item.Payload...)
+
+		var backPointer [aggregateBackPointerLength]byte
+		binary.BigEndian.PutUint32(backPointer[:], uint32(aggregateSubHeaderLength+len(item.Payload)))
+		out = append(out, backPointer[:]...)
+	}
+
+	return out
+}
@@ -0,0 +1,51 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecodeAggregateRoundTrip(t *testing.T) {
+	const base = uint32(1000)
+
+	items := []AggregateItem{
+		{TypeID: TypeIDAudioMessage, Timestamp: base, StreamID: 1, Payload: []byte("audio-0")},
+		{TypeID: TypeIDVideoMessage, Timestamp: base + 33, StreamID: 1, Payload: []byte("video-33")},
+		{TypeID: TypeIDVideoMessage, Timestamp: base + 66, StreamID: 1, Payload: []byte("video-66")},
+	}
+
+	payload := EncodeAggregate(items, base)
+
+	got, err := DecodeAggregate(base, payload)
+	require.NoError(t, err)
+	require.Len(t, got, len(items))
+	for i, item := range items {
+		assert.Equal(t, item.TypeID, got[i].TypeID)
+		assert.Equal(t, item.Timestamp, got[i].Timestamp)
+		assert.Equal(t, item.StreamID, got[i].StreamID)
+		assert.Equal(t, item.Payload, got[i].Payload)
+	}
+}
+
+func TestDecodeAggregateRejectsTruncatedHeader(t *testing.T) {
+	_, err := DecodeAggregate(0, []byte{0x08, 0x00, 0x00})
+	assert.Error(t, err)
+}
+
+func TestDecodeAggregateRejectsTruncatedBody(t *testing.T) {
+	payload := EncodeAggregate([]AggregateItem{
+		{TypeID: TypeIDAudioMessage, Timestamp: 0, StreamID: 0, Payload: []byte("hello")},
+	}, 0)
+
+	_, err := DecodeAggregate(0, payload[:len(payload)-3])
+	assert.Error(t, err)
+}
@@ -0,0 +1,14 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package message
+
+// Message is implemented by every decodable/encodable RTMP message
+// payload (Audio, Video, Ack, AMF command/data messages, ...).
+type Message interface {
+	TypeID() TypeID
+}
@@ -0,0 +1,34 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package message
+
+// TypeID identifies the kind of payload carried by an RTMP message, as
+// set in the message header's "Message Type ID" field.
+type TypeID byte
+
+const (
+	TypeIDSetChunkSize     TypeID = 1
+	TypeIDAbort            TypeID = 2
+	TypeIDAck              TypeID = 3
+	TypeIDUserControl      TypeID = 4
+	TypeIDWindowAckSize    TypeID = 5
+	TypeIDSetPeerBandwidth TypeID = 6
+
+	TypeIDAudioMessage TypeID = 8
+	TypeIDVideoMessage TypeID = 9
+
+	TypeIDDataMessageAMF3         TypeID = 15
+	TypeIDSharedObjectMessageAMF3 TypeID = 16
+	TypeIDCommandMessageAMF3      TypeID = 17
+
+	TypeIDDataMessageAMF0         TypeID = 18
+	TypeIDSharedObjectMessageAMF0 TypeID = 19
+	TypeIDCommandMessageAMF0      TypeID = 20
+
+	TypeIDAggregateMessage TypeID = 22
+)
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+// DefaultChunkSize is the RTMP chunk payload size assumed by both peers
+// before either side sends a Set Chunk Size message.
+const DefaultChunkSize = 128
+
+// VideoDropPolicy controls how the writer scheduler sheds load for the
+// video class once a writer's queue depth crosses WriterHighWaterMark.
+type VideoDropPolicy int
+
+const (
+	// VideoDropPolicyNone never drops video, regardless of queue depth.
+	VideoDropPolicyNone VideoDropPolicy = iota
+
+	// VideoDropPolicyDropNonKeyframe discards queued (not yet partially
+	// sent) non-keyframe messages for a chunk stream once it falls
+	// behind, keeping only keyframes and the in-flight message.
+	VideoDropPolicyDropNonKeyframe
+
+	// VideoDropPolicyCoalesce discards every queued (not yet partially
+	// sent) non-keyframe message for a chunk stream except the newest
+	// queued one, keeping keyframes and the in-flight message. Message
+	// bodies are not merged.
+	VideoDropPolicyCoalesce
+)
+
+// StreamControlStateConfig configures the chunk-level stream control
+// state used by a ChunkStreamer for both the local (self) and remote
+// (peer) sides of a connection, plus the writer scheduler's QoS policy.
+type StreamControlStateConfig struct {
+	ChunkSize       uint32
+	MaxChunkStreams uint32
+	WindowAckSize   uint32
+
+	// WriterHighWaterMark is the number of queued bytes a chunk stream's
+	// writer may accumulate before the scheduler considers it backed up
+	// and applies the per-class drop policy below. Zero disables
+	// backpressure handling (the default, matching prior behavior).
+	WriterHighWaterMark int
+
+	// VideoDropPolicy is applied to the video class once a writer
+	// crosses WriterHighWaterMark.
+	VideoDropPolicy VideoDropPolicy
+
+	// AudioQueueLimit bounds the number of queued (not yet in-flight)
+	// audio messages per chunk stream. Once exceeded, the oldest queued
+	// frame is dropped so audio latency stays bounded on live streams.
+	// Zero disables the bound.
+	AudioQueueLimit int
+
+	// Logger receives the ChunkStreamer's diagnostic output. Nil (the
+	// default) discards it; use NewLogrusLogger to keep the logrus-based
+	// behavior this package had before Logger existed.
+	Logger Logger
+
+	// MaxMessageLength bounds how large a single RTMP message (the
+	// length reconstructed from its chunk header, however many chunks it
+	// takes to deliver) may be before it's refused with
+	// ErrChunkSizeExceeded. Zero disables the bound.
+	MaxMessageLength uint32
+}
+
+// StreamControlState is the mutable state each side (self/peer) of a
+// connection keeps about chunk stream control parameters negotiated via
+// protocol control messages (Set Chunk Size, Window Ack Size, ...).
+type StreamControlState struct {
+	config *StreamControlStateConfig
+
+	chunkSize     uint32
+	ackWindowSize uint32
+}
+
+// NewStreamControlState creates a StreamControlState seeded from config,
+// falling back to RTMP's defaults for fields left unset.
+func NewStreamControlState(config *StreamControlStateConfig) *StreamControlState {
+	chunkSize := config.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	return &StreamControlState{
+		config:        config,
+		chunkSize:     chunkSize,
+		ackWindowSize: config.WindowAckSize,
+	}
+}
@@ -0,0 +1,20 @@
+//
+// Copyright (c) 2018- yutopp (yutopp@gmail.com)
+//
+// Distributed under the Boost Software License, Version 1.0. (See accompanying
+// file LICENSE_1_0.txt or copy at  https://www.boost.org/LICENSE_1_0.txt)
+//
+
+package rtmp
+
+import (
+	"github.com/yutopp/go-rtmp/message"
+)
+
+// StreamFragment is one RTMP message handed between a ChunkStreamer and
+// the higher-level Stream/Conn layer: a decoded message plus the RTMP
+// message stream ID it belongs to.
+type StreamFragment struct {
+	Message  message.Message
+	StreamID uint32
+}